@@ -0,0 +1,52 @@
+package eventsource
+
+import (
+	"net/http"
+	"net/url"
+
+	"github.com/gorilla/websocket"
+)
+
+// SubscribeWS behaves like Subscribe, but connects to a channel served by
+// HandlerWS over a WebSocket rather than text/event-stream. It rewrites
+// http(s) URLs to the matching ws(s) scheme so callers can pass the same
+// kind of URL they would to Subscribe.
+func SubscribeWS(rawurl, lastEventId string) (*Stream, error) {
+	u, err := url.Parse(rawurl)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "http":
+		u.Scheme = "ws"
+	case "https":
+		u.Scheme = "wss"
+	}
+	header := make(http.Header)
+	if lastEventId != "" {
+		header.Set("Last-Event-ID", lastEventId)
+	}
+	conn, _, err := websocket.DefaultDialer.Dial(u.String(), header)
+	if err != nil {
+		return nil, err
+	}
+	stream := &Stream{
+		Events: make(chan Event),
+		Errors: make(chan error),
+	}
+	go wsReadLoop(conn, stream)
+	return stream, nil
+}
+
+func wsReadLoop(conn *websocket.Conn, stream *Stream) {
+	defer conn.Close()
+	defer close(stream.Events)
+	for {
+		var frame wsFrame
+		if err := conn.ReadJSON(&frame); err != nil {
+			stream.Errors <- err
+			return
+		}
+		stream.Events <- frame
+	}
+}
@@ -0,0 +1,67 @@
+package eventsource
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	subscribersDesc = prometheus.NewDesc(
+		"eventsource_subscribers",
+		"Number of subscribers currently connected, by channel.",
+		[]string{"channel"}, nil,
+	)
+	eventsPublishedDesc = prometheus.NewDesc(
+		"eventsource_events_published_total",
+		"Total number of events published.",
+		nil, nil,
+	)
+	commentsPublishedDesc = prometheus.NewDesc(
+		"eventsource_comments_published_total",
+		"Total number of comments published.",
+		nil, nil,
+	)
+	eventsDeliveredDesc = prometheus.NewDesc(
+		"eventsource_events_delivered_total",
+		"Total number of subscriber deliveries of published events.",
+		nil, nil,
+	)
+	eventsDroppedDesc = prometheus.NewDesc(
+		"eventsource_events_dropped_total",
+		"Total number of events not buffered for a subscriber because its buffer was full under DropOldest/DropNewest; DropOldest evictions are not counted.",
+		nil, nil,
+	)
+	replaysStartedDesc = prometheus.NewDesc(
+		"eventsource_replays_started_total",
+		"Total number of repository/transport history replays started for new subscribers (those sending Last-Event-ID, or all of them when ReplayAll is set).",
+		nil, nil,
+	)
+	averageQueueTimeDesc = prometheus.NewDesc(
+		"eventsource_average_queue_time_seconds",
+		"Mean, over currently connected subscribers, of each subscriber's average time a delivered event spent buffered before being written.",
+		nil, nil,
+	)
+)
+
+// Describe implements prometheus.Collector.
+func (srv *Server) Describe(ch chan<- *prometheus.Desc) {
+	ch <- subscribersDesc
+	ch <- eventsPublishedDesc
+	ch <- commentsPublishedDesc
+	ch <- eventsDeliveredDesc
+	ch <- eventsDroppedDesc
+	ch <- replaysStartedDesc
+	ch <- averageQueueTimeDesc
+}
+
+// Collect implements prometheus.Collector, so a Server can be registered
+// directly with a prometheus.Registry and scraped via promhttp.Handler.
+func (srv *Server) Collect(ch chan<- prometheus.Metric) {
+	m := srv.Metrics()
+	for channel, n := range m.Subscribers {
+		ch <- prometheus.MustNewConstMetric(subscribersDesc, prometheus.GaugeValue, float64(n), channel)
+	}
+	ch <- prometheus.MustNewConstMetric(eventsPublishedDesc, prometheus.CounterValue, float64(m.EventsPublished))
+	ch <- prometheus.MustNewConstMetric(commentsPublishedDesc, prometheus.CounterValue, float64(m.CommentsPublished))
+	ch <- prometheus.MustNewConstMetric(eventsDeliveredDesc, prometheus.CounterValue, float64(m.EventsDelivered))
+	ch <- prometheus.MustNewConstMetric(eventsDroppedDesc, prometheus.CounterValue, float64(m.EventsDropped))
+	ch <- prometheus.MustNewConstMetric(replaysStartedDesc, prometheus.CounterValue, float64(m.ReplaysStarted))
+	ch <- prometheus.MustNewConstMetric(averageQueueTimeDesc, prometheus.GaugeValue, m.AverageQueueTime.Seconds())
+}
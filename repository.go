@@ -0,0 +1,117 @@
+package eventsource
+
+import "sync"
+
+// Repository supplies historical events for a channel. It lets a
+// reconnecting subscriber catch up via Last-Event-ID (see Server.Register)
+// and backs HandlerJSON for clients that can't hold a long-lived
+// connection.
+type Repository interface {
+	// Replay returns a channel of events on channel published after
+	// lastEventId, closed once exhausted. An empty lastEventId replays the
+	// full history.
+	Replay(channel, lastEventId string) chan Event
+	// Range returns every event on channel with an id after since and, if
+	// until is non-empty, at or before until, most recent history last.
+	// limit caps the number of events returned, keeping the oldest of them
+	// so a caller paging forward with since never skips a gap; limit <= 0
+	// means unlimited.
+	Range(channel, since, until string, limit int) []Event
+}
+
+// SliceRepository is the bundled Repository implementation: it retains, per
+// channel, a capped in-memory slice of events, evicting the oldest once
+// limit is exceeded. It's what Register is typically used with, and what
+// HandlerJSON's Range support was written against; reach for NewBoltTransport
+// instead if retained history needs to survive a process restart.
+type SliceRepository struct {
+	mu       sync.Mutex
+	limit    int
+	channels map[string][]Event
+}
+
+// NewSliceRepository creates a SliceRepository that retains, per channel, at
+// most limit events. limit <= 0 means unlimited.
+func NewSliceRepository(limit int) *SliceRepository {
+	return &SliceRepository{
+		limit:    limit,
+		channels: make(map[string][]Event),
+	}
+}
+
+// Add appends ev to channel's retained history, evicting the oldest event if
+// limit is exceeded. Callers typically call this alongside Server.Publish so
+// every published event is also retained for replay.
+func (r *SliceRepository) Add(channel string, ev Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	events := append(r.channels[channel], ev)
+	if r.limit > 0 && len(events) > r.limit {
+		events = events[len(events)-r.limit:]
+	}
+	r.channels[channel] = events
+}
+
+// Replay implements Repository.
+func (r *SliceRepository) Replay(channel, lastEventId string) chan Event {
+	r.mu.Lock()
+	events := r.eventsAfter(channel, lastEventId)
+	r.mu.Unlock()
+	out := make(chan Event)
+	go func() {
+		defer close(out)
+		for _, ev := range events {
+			out <- ev
+		}
+	}()
+	return out
+}
+
+// Range implements Repository. If until doesn't match a retained event -
+// for instance because it's old enough to have already been evicted - there
+// is no way to tell whether it precedes or follows what's left, so Range
+// conservatively returns no events rather than risk returning ones at or
+// after it.
+func (r *SliceRepository) Range(channel, since, until string, limit int) []Event {
+	r.mu.Lock()
+	events := r.eventsAfter(channel, since)
+	r.mu.Unlock()
+	if until != "" {
+		found := false
+		for i, ev := range events {
+			if ev.Id() == until {
+				events = events[:i+1]
+				found = true
+				break
+			}
+		}
+		if !found {
+			events = nil
+		}
+	}
+	if limit > 0 && len(events) > limit {
+		// Keep the oldest limit events, not the newest: a caller paging
+		// forward remembers the last id it was given and passes it as since
+		// next time, so truncating from the front would skip the events in
+		// between and never come back for them.
+		events = events[:limit]
+	}
+	return events
+}
+
+// eventsAfter returns a copy of channel's retained events with an id after
+// afterId, or the full retained history if afterId is empty or unknown. It
+// must be called with r.mu held.
+func (r *SliceRepository) eventsAfter(channel, afterId string) []Event {
+	all := r.channels[channel]
+	start := 0
+	if afterId != "" {
+		for i, ev := range all {
+			if ev.Id() == afterId {
+				start = i + 1
+				break
+			}
+		}
+	}
+	return append([]Event(nil), all[start:]...)
+}
@@ -0,0 +1,43 @@
+package eventsource
+
+import "testing"
+
+func TestSliceRepositoryRangeUnknownUntil(t *testing.T) {
+	repo := NewSliceRepository(2)
+	repo.Add("c", testEvent("1"))
+	repo.Add("c", testEvent("2"))
+	repo.Add("c", testEvent("3")) // evicts "1"
+
+	got := repo.Range("c", "", "1", 0)
+	if len(got) != 0 {
+		t.Fatalf("Range with an evicted until = %v, want no events", got)
+	}
+}
+
+func TestSliceRepositoryRangeKnownUntil(t *testing.T) {
+	repo := NewSliceRepository(0)
+	repo.Add("c", testEvent("1"))
+	repo.Add("c", testEvent("2"))
+	repo.Add("c", testEvent("3"))
+
+	got := repo.Range("c", "", "2", 0)
+	if len(got) != 2 || got[0].Id() != "1" || got[1].Id() != "2" {
+		t.Fatalf("Range(until=2) = %v, want [1 2]", got)
+	}
+}
+
+func TestSliceRepositoryRangeLimitKeepsOldest(t *testing.T) {
+	repo := NewSliceRepository(0)
+	repo.Add("c", testEvent("1"))
+	repo.Add("c", testEvent("2"))
+	repo.Add("c", testEvent("3"))
+
+	// A forward poller remembers the last id it was given and passes it as
+	// since next time, so a capped response must return the oldest events
+	// after since, not the newest - otherwise the skipped middle is never
+	// re-fetched.
+	got := repo.Range("c", "", "", 2)
+	if len(got) != 2 || got[0].Id() != "1" || got[1].Id() != "2" {
+		t.Fatalf("Range(limit=2) = %v, want [1 2]", got)
+	}
+}
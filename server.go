@@ -2,15 +2,33 @@ package eventsource
 
 import (
 	"bytes"
+	"encoding/json"
 	"log"
 	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
 )
 
 type subscription struct {
 	channel     string
+	topics      []string // topics this subscription receives events for; normally just {channel}
 	lastEventId string
+	remoteAddr  string
+	connectedAt time.Time
 	out         chan Event
 	cout        chan string
+	policy      SlowClientPolicy
+	delivered   uint64
+	dropped     uint64
+	removed     bool
+	// queueTimes mirrors out's capacity with the time each event was
+	// handed to the transport, so the consuming Handler/HandlerWS can
+	// measure time-in-queue on the way out. Best effort: under DropOldest
+	// the two can briefly disagree, which just skews queueNanos slightly.
+	queueTimes   chan time.Time
+	queueNanos   uint64 // atomic: total nanoseconds events spent queued
+	queueSamples uint64 // atomic: number of samples behind queueNanos
 }
 
 func (s *subscription) destroy() {
@@ -18,46 +36,132 @@ func (s *subscription) destroy() {
 	close(s.cout)
 }
 
+// recordDequeue measures how long the event just received from s.out spent
+// queued, if a matching timestamp is available.
+func (s *subscription) recordDequeue() {
+	select {
+	case t := <-s.queueTimes:
+		atomic.AddUint64(&s.queueNanos, uint64(time.Since(t)))
+		atomic.AddUint64(&s.queueSamples, 1)
+	default:
+	}
+}
+
+// SlowClientPolicy controls what a Server does when a subscriber's output
+// buffer is full at publish time.
+type SlowClientPolicy int
+
+const (
+	// Disconnect unregisters and closes a subscriber whose buffer is full.
+	// This is the default, and was the only behaviour before
+	// SlowClientPolicy was introduced.
+	Disconnect SlowClientPolicy = iota
+	// DropOldest discards the oldest buffered message to make room for the
+	// new one, keeping the subscriber connected at the cost of a gap in
+	// what it receives.
+	DropOldest
+	// DropNewest discards the incoming message instead of anything already
+	// buffered for the subscriber.
+	DropNewest
+	// Block sends to the subscriber's buffer regardless of how full it is,
+	// which stalls dispatch to every other subscriber until it catches up.
+	Block
+)
+
+type ackResult struct {
+	delivered    int
+	disconnected int
+}
+
 type outbound struct {
 	channels []string
 	event    Event
+	ack      chan ackResult
 }
 type registration struct {
 	channel    string
 	repository Repository
 }
+type repoRequest struct {
+	channel string
+	result  chan Repository
+}
+type subscribersRequest struct {
+	channel string
+	result  chan []SubscriberInfo
+}
+
+// metricsCounters accumulates the lifetime totals behind Metrics. It is
+// only ever touched from within Server.run(), so needs no locking.
+type metricsCounters struct {
+	eventsPublished   uint64
+	commentsPublished uint64
+	eventsDelivered   uint64
+	eventsDropped     uint64
+	replaysStarted    uint64
+}
 type outComment struct {
 	channels []string
 	comment  string
 }
 
+// Authorizer, if set as Server.Authorizer, is called before enrolling a new
+// subscription on channel and returns the topics that connection is
+// allowed to receive. Returning an error refuses the subscription. This
+// lets a single channel/endpoint multiplex many private, per-user topics -
+// Publish targets topics, not necessarily the channel a Handler was
+// created for - without every caller reimplementing connection setup,
+// replay and CORS handling themselves.
+type Authorizer func(req *http.Request, channel string) (topics []string, err error)
+
 type Server struct {
-	AllowCORS     bool // Enable all handlers to be accessible from any origin
-	ReplayAll     bool // Replay repository even if there's no Last-Event-Id specified
-	BufferSize    int  // How many messages do we let the client get behind before disconnecting
-	registrations chan *registration
-	pub           chan *outbound
-	subs          chan *subscription
-	comments      chan *outComment
-	unregister    chan *subscription
-	quit          chan bool
-	kill          chan string
-	deadChannels  map[string]bool
-	dead          bool
-}
-
-// Create a new Server ready for handler creation and publishing events
+	AllowCORS         bool             // Enable all handlers to be accessible from any origin
+	ReplayAll         bool             // Replay repository even if there's no Last-Event-Id specified
+	BufferSize        int              // How many messages do we let the client get behind before SlowClientPolicy applies
+	KeepaliveInterval time.Duration    // If non-zero, period between keepalive comments sent to every subscriber
+	SlowClientPolicy  SlowClientPolicy // What to do when a subscriber's buffer is full; defaults to Disconnect
+	Authorizer        Authorizer       // If set, authorizes and scopes down the topics of each new subscription
+	registrations     chan *registration
+	pub               chan *outbound
+	subs              chan *subscription
+	comments          chan *outComment
+	unregister        chan *subscription
+	statsReq          chan chan []SubscriberStats
+	repoReq           chan *repoRequest
+	subscribersReq    chan *subscribersRequest
+	metricsReq        chan chan Metrics
+	quit              chan bool
+	kill              chan string
+	deadChannels      map[string]bool
+	dead              bool
+	transport         Transport
+}
+
+// Create a new Server ready for handler creation and publishing events,
+// using the default in-memory Transport.
 func NewServer() *Server {
+	return NewServerWithTransport(newMemoryTransport())
+}
+
+// NewServerWithTransport creates a new Server backed by the given Transport
+// in place of the default in-memory one, for instance one that keeps replay
+// history on disk (see NewBoltTransport).
+func NewServerWithTransport(transport Transport) *Server {
 	srv := &Server{
-		registrations: make(chan *registration),
-		pub:           make(chan *outbound),
-		comments:      make(chan *outComment),
-		subs:          make(chan *subscription),
-		unregister:    make(chan *subscription, 2),
-		quit:          make(chan bool),
-		kill:          make(chan string),
-		deadChannels:  make(map[string]bool),
-		BufferSize:    128,
+		registrations:  make(chan *registration),
+		pub:            make(chan *outbound),
+		comments:       make(chan *outComment),
+		subs:           make(chan *subscription),
+		unregister:     make(chan *subscription, 2),
+		statsReq:       make(chan chan []SubscriberStats),
+		repoReq:        make(chan *repoRequest),
+		subscribersReq: make(chan *subscribersRequest),
+		metricsReq:     make(chan chan Metrics),
+		quit:           make(chan bool),
+		kill:           make(chan string),
+		deadChannels:   make(map[string]bool),
+		BufferSize:     128,
+		transport:      transport,
 	}
 	go srv.run()
 	return srv
@@ -91,11 +195,21 @@ func (srv *Server) Handler(channel string) http.HandlerFunc {
 		if srv.AllowCORS {
 			h.Set("Access-Control-Allow-Origin", "*")
 		}
+		topics, err := srv.authorize(req, channel)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		}
 		sub := &subscription{
 			channel:     channel,
+			topics:      topics,
 			lastEventId: req.Header.Get("Last-Event-ID"),
+			remoteAddr:  req.RemoteAddr,
+			connectedAt: time.Now(),
 			out:         make(chan Event, srv.BufferSize),
 			cout:        make(chan string, srv.BufferSize),
+			queueTimes:  make(chan time.Time, srv.BufferSize),
+			policy:      srv.SlowClientPolicy,
 		}
 		srv.subs <- sub
 		flusher := w.(http.Flusher)
@@ -116,6 +230,7 @@ func (srv *Server) Handler(channel string) http.HandlerFunc {
 				if !ok {
 					return
 				}
+				sub.recordDequeue()
 				if err := enc.Encode(ev); err != nil {
 					srv.unregister <- sub
 					log.Println(err)
@@ -137,6 +252,62 @@ func (srv *Server) Handler(channel string) http.HandlerFunc {
 	}
 }
 
+// authorize returns the topics req is allowed to receive for channel: just
+// channel itself if no Authorizer is configured, or whatever the
+// Authorizer returns.
+func (srv *Server) authorize(req *http.Request, channel string) ([]string, error) {
+	if srv.Authorizer == nil {
+		return []string{channel}, nil
+	}
+	return srv.Authorizer(req, channel)
+}
+
+// HandlerJSON creates a handler serving a specified channel's history as a
+// single JSON response, for clients that can't hold a long-lived
+// connection (mobile, serverless, batch pollers): they poll periodically,
+// remember the last id they were given, and pass it as since next time. It
+// supports ?since=<id>&until=<id>&limit=N query parameters and is backed by
+// the Repository registered for channel via Register.
+func (srv *Server) HandlerJSON(channel string) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if srv.AllowCORS {
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		}
+		repo := srv.repository(channel)
+		if repo == nil {
+			http.Error(w, "no repository registered for this channel", http.StatusNotFound)
+			return
+		}
+		q := req.URL.Query()
+		limit := 0
+		if raw := q.Get("limit"); raw != "" {
+			n, err := strconv.Atoi(raw)
+			if err != nil {
+				http.Error(w, "invalid limit", http.StatusBadRequest)
+				return
+			}
+			limit = n
+		}
+		events := repo.Range(channel, q.Get("since"), q.Get("until"), limit)
+		frames := make([]wsFrame, len(events))
+		for i, ev := range events {
+			frames[i] = wsFrame{ID: ev.Id(), Type: ev.Event(), Payload: ev.Data()}
+		}
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		if err := json.NewEncoder(w).Encode(frames); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// repository synchronously looks up the Repository registered for channel,
+// if any.
+func (srv *Server) repository(channel string) Repository {
+	result := make(chan Repository, 1)
+	srv.repoReq <- &repoRequest{channel: channel, result: result}
+	return <-result
+}
+
 // Register the repository to be used for the specified channel
 func (srv *Server) Register(channel string, repo Repository) {
 	srv.registrations <- &registration{
@@ -153,6 +324,102 @@ func (srv *Server) Publish(channels []string, ev Event) {
 	}
 }
 
+// PublishAndWait behaves like Publish, but blocks until the event has been
+// dispatched and reports how many subscribers received it versus were
+// disconnected for being too slow (per SlowClientPolicy). It gives up and
+// returns zero values if timeout elapses first.
+func (srv *Server) PublishAndWait(channels []string, ev Event, timeout time.Duration) (delivered, disconnected int) {
+	ack := make(chan ackResult, 1)
+	srv.pub <- &outbound{
+		channels: channels,
+		event:    ev,
+		ack:      ack,
+	}
+	select {
+	case result := <-ack:
+		return result.delivered, result.disconnected
+	case <-time.After(timeout):
+		return 0, 0
+	}
+}
+
+// SubscriberStats reports the delivery health of one connected subscriber,
+// letting operators diagnose a slow consumer instead of only seeing it
+// vanish.
+type SubscriberStats struct {
+	Channel          string
+	Queued           int           // Events currently buffered and not yet delivered
+	Delivered        uint64        // Events placed in the subscriber's buffer so far, including any later evicted under DropOldest
+	Dropped          uint64        // Events not buffered because the buffer was full under DropOldest/DropNewest; DropOldest evictions are not counted
+	AverageQueueTime time.Duration // Average time a delivered event spent queued
+}
+
+// Stats returns delivery statistics for every currently connected
+// subscriber.
+func (srv *Server) Stats() []SubscriberStats {
+	req := make(chan []SubscriberStats, 1)
+	srv.statsReq <- req
+	return <-req
+}
+
+// Subscribers returns introspection info - remote address, connect time,
+// Last-Event-ID and queue depth - for every subscriber currently connected
+// to channel.
+func (srv *Server) Subscribers(channel string) []SubscriberInfo {
+	result := make(chan []SubscriberInfo, 1)
+	srv.subscribersReq <- &subscribersRequest{channel: channel, result: result}
+	return <-result
+}
+
+// Metrics is a point-in-time snapshot of a Server's activity, suitable for
+// exposing over HTTP or adapting into a monitoring system; see also
+// Server's prometheus.Collector implementation in metrics_prometheus.go.
+type Metrics struct {
+	Subscribers       map[string]int // currently connected subscribers, by channel
+	EventsPublished   uint64
+	CommentsPublished uint64
+	EventsDelivered   uint64
+	EventsDropped     uint64
+	ReplaysStarted    uint64
+	AverageQueueTime  time.Duration // mean of each subscriber's own average queue time
+}
+
+// Metrics returns a snapshot of the Server's activity since it was created.
+func (srv *Server) Metrics() Metrics {
+	req := make(chan Metrics, 1)
+	srv.metricsReq <- req
+	return <-req
+}
+
+// snapshotMetrics builds a Metrics snapshot from the running counters and
+// the transport's current subscriber stats. It must only be called from
+// run().
+func (srv *Server) snapshotMetrics(m metricsCounters, channels map[string]struct{}) Metrics {
+	subs := make(map[string]int, len(channels))
+	for c := range channels {
+		subs[c] = 0
+	}
+	var totalQueueTime time.Duration
+	stats := srv.transport.Stats()
+	for _, s := range stats {
+		subs[s.Channel]++
+		totalQueueTime += s.AverageQueueTime
+	}
+	var avgQueueTime time.Duration
+	if len(stats) > 0 {
+		avgQueueTime = totalQueueTime / time.Duration(len(stats))
+	}
+	return Metrics{
+		Subscribers:       subs,
+		EventsPublished:   m.eventsPublished,
+		CommentsPublished: m.commentsPublished,
+		EventsDelivered:   m.eventsDelivered,
+		EventsDropped:     m.eventsDropped,
+		ReplaysStarted:    m.replaysStarted,
+		AverageQueueTime:  avgQueueTime,
+	}
+}
+
 func (srv *Server) PublishComment(channels []string, comment string) {
 	srv.comments <- &outComment{
 		channels: channels,
@@ -171,66 +438,83 @@ func replay(repo Repository, sub *subscription) {
 	}
 }
 
+func replayTransport(transport Transport, sub *subscription) {
+	for ev := range transport.History(sub.channel, sub.lastEventId) {
+		sub.out <- ev
+	}
+}
+
 func (srv *Server) run() {
-	subs := make(map[string]map[*subscription]struct{})
 	repos := make(map[string]Repository)
+	channels := make(map[string]struct{})
+	var m metricsCounters
+	// KeepaliveInterval is normally set on the Server after NewServer has
+	// already started run(), so the ticker is started on the first pass
+	// through the loop that finds it non-zero.
+	var keepalive <-chan time.Time
+	var ticker *time.Ticker
+	defer func() {
+		if ticker != nil {
+			ticker.Stop()
+		}
+	}()
 	for {
+		if ticker == nil && srv.KeepaliveInterval > 0 {
+			ticker = time.NewTicker(srv.KeepaliveInterval)
+			keepalive = ticker.C
+		}
 		select {
+		case <-keepalive:
+			srv.transport.Broadcast(":keepalive")
 		case reg := <-srv.registrations:
 			repos[reg.channel] = reg.repository
+			channels[reg.channel] = struct{}{}
 		case sub := <-srv.unregister:
-			sub.destroy()
-			delete(subs[sub.channel], sub)
+			srv.transport.RemoveSubscriber(sub)
 		case pub := <-srv.pub:
-			for _, c := range pub.channels {
-				for s := range subs[c] {
-					select {
-					case s.out <- pub.event:
-					default:
-						srv.unregister <- s
-					}
-
-				}
+			delivered, dropped, disconnected := srv.transport.Dispatch(pub.channels, pub.event)
+			m.eventsPublished++
+			m.eventsDelivered += uint64(delivered)
+			m.eventsDropped += uint64(dropped)
+			if pub.ack != nil {
+				pub.ack <- ackResult{delivered: delivered, disconnected: disconnected}
 			}
 		case cmt := <-srv.comments:
-			for _, c := range cmt.channels {
-				for s := range subs[c] {
-					select {
-					case s.cout <- cmt.comment:
-					default:
-						srv.unregister <- s
-					}
-
-				}
-			}
+			srv.transport.DispatchComment(cmt.channels, cmt.comment)
+			m.commentsPublished++
+		case req := <-srv.statsReq:
+			req <- srv.transport.Stats()
+		case req := <-srv.repoReq:
+			req.result <- repos[req.channel]
+		case req := <-srv.subscribersReq:
+			req.result <- srv.transport.Subscribers(req.channel)
+		case req := <-srv.metricsReq:
+			req <- srv.snapshotMetrics(m, channels)
 		case die := <-srv.kill:
-			for s := range subs[die] {
-				s.destroy()
-				delete(subs[die], s)
-			}
+			srv.transport.CloseChannel(die)
 			srv.deadChannels[die] = true
 		case sub := <-srv.subs:
-			if _, ok := subs[sub.channel]; !ok {
-				subs[sub.channel] = make(map[*subscription]struct{})
-			}
-			subs[sub.channel][sub] = struct{}{}
+			channels[sub.channel] = struct{}{}
+			srv.transport.AddSubscriber(sub)
 			if srv.ReplayAll || len(sub.lastEventId) > 0 {
-				repo, ok := repos[sub.channel]
-				if ok {
+				m.replaysStarted++
+				if repo, ok := repos[sub.channel]; ok {
 					go replay(repo, sub)
+				} else {
+					go replayTransport(srv.transport, sub)
 				}
 			}
 		case <-srv.quit:
-			for _, sub := range subs {
-				for s := range sub {
-					s.destroy()
-				}
-			}
+			srv.transport.Close()
 			close(srv.registrations)
 			close(srv.pub)
 			close(srv.comments)
 			close(srv.subs)
 			close(srv.unregister)
+			close(srv.statsReq)
+			close(srv.repoReq)
+			close(srv.subscribersReq)
+			close(srv.metricsReq)
 			close(srv.quit)
 			close(srv.kill)
 			srv.dead = true
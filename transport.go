@@ -0,0 +1,312 @@
+package eventsource
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Transport owns the bookkeeping and fan-out that used to live directly
+// inside Server.run(): tracking which subscriptions exist for a channel and
+// delivering published events and comments to them. Swapping the default
+// in-memory Transport for one backed by durable storage (see
+// NewBoltTransport) keeps the history used for Last-Event-ID replay across
+// process restarts.
+type Transport interface {
+	// AddSubscriber registers sub to receive events and comments published
+	// to any of sub.topics (sub.channel alone when no Authorizer scoped it
+	// down further).
+	AddSubscriber(sub *subscription)
+	// RemoveSubscriber unregisters sub and closes its channels. It is a
+	// no-op if sub is not currently registered.
+	RemoveSubscriber(sub *subscription)
+	// Dispatch delivers ev to every subscriber currently registered for any
+	// of channels, honouring each subscriber's SlowClientPolicy, and
+	// reports how many received it, were dropped and were disconnected for
+	// being too slow.
+	Dispatch(channels []string, ev Event) (delivered, dropped, disconnected int)
+	// DispatchComment delivers comment to every subscriber currently
+	// registered for any of channels, honouring each subscriber's
+	// SlowClientPolicy.
+	DispatchComment(channels []string, comment string)
+	// Stats returns delivery statistics for every currently registered
+	// subscriber.
+	Stats() []SubscriberStats
+	// Subscribers returns introspection info for every subscriber
+	// currently registered for channel.
+	Subscribers(channel string) []SubscriberInfo
+	// History returns, on a channel that is closed once exhausted, every
+	// event the Transport has retained for channel published after
+	// lastEventID. An empty lastEventID replays everything retained.
+	// Implementations with no durable storage (such as the default
+	// in-memory Transport) may return an already-closed channel.
+	History(channel, lastEventID string) <-chan Event
+	// CloseChannel disconnects and removes every subscriber whose handler
+	// channel is channel, regardless of which topics they were scoped down
+	// to by an Authorizer.
+	CloseChannel(channel string)
+	// Close disconnects every subscriber of every channel and releases any
+	// resources held by the Transport.
+	Close()
+	// Broadcast delivers comment to every currently connected subscriber,
+	// across every channel and topic. It's used for server-wide keepalives,
+	// which are a connection-level concern rather than something scoped to
+	// whatever topics a subscriber happens to be authorized for.
+	Broadcast(comment string)
+}
+
+// memoryTransport is the default Transport: subscribers are kept in plain
+// maps and events are only ever delivered to whoever is connected at
+// publish time. It retains no history of its own; replay for reconnecting
+// subscribers is instead handled by a registered Repository (see
+// Server.Register) when one is present.
+type memoryTransport struct {
+	// subs indexes subscribers by topic, for Dispatch/DispatchComment
+	// delivery; a subscriber scoped to several topics by an Authorizer
+	// appears once per topic.
+	subs map[string]map[*subscription]struct{}
+	// byChannel indexes subscribers by the literal channel their Handler
+	// was created for, independent of whichever topics they're scoped to.
+	// Every connected subscriber appears exactly once here, so it backs the
+	// operations that are about the connection itself rather than content
+	// routing: CloseChannel, Close, Stats, Subscribers and Broadcast.
+	byChannel map[string]map[*subscription]struct{}
+}
+
+func newMemoryTransport() *memoryTransport {
+	return &memoryTransport{
+		subs:      make(map[string]map[*subscription]struct{}),
+		byChannel: make(map[string]map[*subscription]struct{}),
+	}
+}
+
+func (t *memoryTransport) AddSubscriber(sub *subscription) {
+	for _, topic := range sub.topics {
+		if _, ok := t.subs[topic]; !ok {
+			t.subs[topic] = make(map[*subscription]struct{})
+		}
+		t.subs[topic][sub] = struct{}{}
+	}
+	if _, ok := t.byChannel[sub.channel]; !ok {
+		t.byChannel[sub.channel] = make(map[*subscription]struct{})
+	}
+	t.byChannel[sub.channel][sub] = struct{}{}
+}
+
+func (t *memoryTransport) RemoveSubscriber(sub *subscription) {
+	if sub.removed {
+		return
+	}
+	sub.removed = true
+	for _, topic := range sub.topics {
+		delete(t.subs[topic], sub)
+	}
+	delete(t.byChannel[sub.channel], sub)
+	sub.destroy()
+}
+
+type deliveryResult int
+
+const (
+	delivered deliveryResult = iota
+	dropped
+	disconnected
+)
+
+// deliver sends ev to s.out, applying s.policy if the buffer is already
+// full, and updates s's delivered/dropped counters accordingly.
+func (t *memoryTransport) deliver(s *subscription, ev Event) deliveryResult {
+	select {
+	case s.out <- ev:
+		s.delivered++
+		stampQueueTime(s)
+		return delivered
+	default:
+	}
+	switch s.policy {
+	case DropOldest:
+		select {
+		case <-s.out:
+			select {
+			case <-s.queueTimes:
+			default:
+			}
+		default:
+		}
+		select {
+		case s.out <- ev:
+			s.delivered++
+			stampQueueTime(s)
+			return delivered
+		default:
+			s.dropped++
+			return dropped
+		}
+	case DropNewest:
+		s.dropped++
+		return dropped
+	case Block:
+		s.out <- ev
+		s.delivered++
+		stampQueueTime(s)
+		return delivered
+	default: // Disconnect
+		t.RemoveSubscriber(s)
+		return disconnected
+	}
+}
+
+// stampQueueTime best-effort records when an event was handed off, so the
+// consuming Handler/HandlerWS can later measure time-in-queue.
+func stampQueueTime(s *subscription) {
+	select {
+	case s.queueTimes <- time.Now():
+	default:
+	}
+}
+
+// deliverComment is deliver's counterpart for s.cout; comments aren't
+// acknowledged, so it has nothing to report back.
+func (t *memoryTransport) deliverComment(s *subscription, comment string) {
+	select {
+	case s.cout <- comment:
+		return
+	default:
+	}
+	switch s.policy {
+	case DropOldest:
+		select {
+		case <-s.cout:
+		default:
+		}
+		select {
+		case s.cout <- comment:
+		default:
+		}
+	case DropNewest:
+	case Block:
+		s.cout <- comment
+	default: // Disconnect
+		t.RemoveSubscriber(s)
+	}
+}
+
+// Dispatch delivers ev to every subscriber registered for any of channels,
+// at most once each: a subscriber scoped by an Authorizer to several of
+// channels must not receive (or be counted for) the same event more than
+// once.
+func (t *memoryTransport) Dispatch(channels []string, ev Event) (deliveredCount, droppedCount, disconnectedCount int) {
+	seen := make(map[*subscription]struct{})
+	for _, c := range channels {
+		for s := range t.subs[c] {
+			if _, ok := seen[s]; ok {
+				continue
+			}
+			seen[s] = struct{}{}
+			switch t.deliver(s, ev) {
+			case delivered:
+				deliveredCount++
+			case dropped:
+				droppedCount++
+			case disconnected:
+				disconnectedCount++
+			}
+		}
+	}
+	return
+}
+
+// DispatchComment is Dispatch's counterpart for comments; see Dispatch for
+// why subscribers spanning multiple of channels are deduplicated.
+func (t *memoryTransport) DispatchComment(channels []string, comment string) {
+	seen := make(map[*subscription]struct{})
+	for _, c := range channels {
+		for s := range t.subs[c] {
+			if _, ok := seen[s]; ok {
+				continue
+			}
+			seen[s] = struct{}{}
+			t.deliverComment(s, comment)
+		}
+	}
+}
+
+// Stats returns one entry per distinct connected subscriber, keyed by its
+// handler channel rather than whatever topics an Authorizer scoped it down
+// to - a subscriber authorized for several topics must still only be
+// reported once.
+func (t *memoryTransport) Stats() []SubscriberStats {
+	var stats []SubscriberStats
+	for channel, subs := range t.byChannel {
+		for s := range subs {
+			stats = append(stats, SubscriberStats{
+				Channel:          channel,
+				Queued:           len(s.out),
+				Delivered:        s.delivered,
+				Dropped:          s.dropped,
+				AverageQueueTime: averageQueueTime(s),
+			})
+		}
+	}
+	return stats
+}
+
+func averageQueueTime(s *subscription) time.Duration {
+	nanos := atomic.LoadUint64(&s.queueNanos)
+	samples := atomic.LoadUint64(&s.queueSamples)
+	if samples == 0 {
+		return 0
+	}
+	return time.Duration(nanos / samples)
+}
+
+// SubscriberInfo is a point-in-time snapshot of one subscriber's connection,
+// for operator-facing introspection endpoints.
+type SubscriberInfo struct {
+	RemoteAddr  string
+	ConnectedAt time.Time
+	LastEventId string
+	Queued      int
+}
+
+func (t *memoryTransport) Subscribers(channel string) []SubscriberInfo {
+	var out []SubscriberInfo
+	for s := range t.byChannel[channel] {
+		out = append(out, SubscriberInfo{
+			RemoteAddr:  s.remoteAddr,
+			ConnectedAt: s.connectedAt,
+			LastEventId: s.lastEventId,
+			Queued:      len(s.out),
+		})
+	}
+	return out
+}
+
+func (t *memoryTransport) History(channel, lastEventID string) <-chan Event {
+	out := make(chan Event)
+	close(out)
+	return out
+}
+
+// CloseChannel disconnects every subscriber whose handler channel is
+// channel. It uses byChannel rather than subs so a subscriber scoped by an
+// Authorizer to topics other than channel is still found and disconnected.
+func (t *memoryTransport) CloseChannel(channel string) {
+	for s := range t.byChannel[channel] {
+		t.RemoveSubscriber(s)
+	}
+}
+
+func (t *memoryTransport) Close() {
+	for channel := range t.byChannel {
+		t.CloseChannel(channel)
+	}
+}
+
+// Broadcast implements Transport.
+func (t *memoryTransport) Broadcast(comment string) {
+	for _, subs := range t.byChannel {
+		for s := range subs {
+			t.deliverComment(s, comment)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+package eventsource
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"log"
+
+	"github.com/boltdb/bolt"
+)
+
+// boltEvent is the on-disk representation of an Event stored by
+// boltTransport. Its fields must stay exported for encoding/gob.
+type boltEvent struct {
+	ID      string
+	Type    string
+	Payload string
+}
+
+func (e boltEvent) Id() string    { return e.ID }
+func (e boltEvent) Event() string { return e.Type }
+func (e boltEvent) Data() string  { return e.Payload }
+
+// idKeyPrefix namespaces the secondary id->sequence index inside a channel's
+// bucket away from the sequence-keyed event records themselves.
+const idKeyPrefix = "id:"
+
+// boltTransport is a Transport that durably appends every published event to
+// a BoltDB database before delivering it to live subscribers, keyed by a
+// per-channel, monotonically increasing sequence number. Last-Event-ID
+// replay is served from disk via History, so a subscriber reconnecting after
+// a process restart is still caught up. Live subscribers and dispatch are
+// kept in process memory, as in the default Transport, and BoltDB holds an
+// exclusive lock on its file while it is open, so a database at a given path
+// is used by one process at a time; processes do not share events or
+// subscribers through it.
+type boltTransport struct {
+	*memoryTransport
+	db *bolt.DB
+}
+
+// NewBoltTransport opens (creating if necessary) a BoltDB database at path
+// and returns a Transport backed by it, suitable for passing to
+// NewServerWithTransport.
+func NewBoltTransport(path string) (Transport, error) {
+	db, err := bolt.Open(path, 0600, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &boltTransport{
+		memoryTransport: newMemoryTransport(),
+		db:              db,
+	}, nil
+}
+
+func (t *boltTransport) Dispatch(channels []string, ev Event) (delivered, dropped, disconnected int) {
+	if err := t.append(channels, ev); err != nil {
+		log.Println("eventsource: bolt transport append failed:", err)
+	}
+	return t.memoryTransport.Dispatch(channels, ev)
+}
+
+func (t *boltTransport) append(channels []string, ev Event) error {
+	stored := boltEvent{ID: ev.Id(), Type: ev.Event(), Payload: ev.Data()}
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(stored); err != nil {
+		return err
+	}
+	return t.db.Update(func(tx *bolt.Tx) error {
+		for _, channel := range channels {
+			bucket, err := tx.CreateBucketIfNotExists([]byte(channel))
+			if err != nil {
+				return err
+			}
+			seq, err := bucket.NextSequence()
+			if err != nil {
+				return err
+			}
+			key := make([]byte, 8)
+			binary.BigEndian.PutUint64(key, seq)
+			if err := bucket.Put(key, buf.Bytes()); err != nil {
+				return err
+			}
+			if err := bucket.Put([]byte(idKeyPrefix+stored.ID), key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// History replays, from disk, every event published to channel after
+// lastEventID. An empty or unknown lastEventID replays the whole retained
+// history.
+func (t *boltTransport) History(channel, lastEventID string) <-chan Event {
+	out := make(chan Event)
+	go func() {
+		defer close(out)
+		err := t.db.View(func(tx *bolt.Tx) error {
+			bucket := tx.Bucket([]byte(channel))
+			if bucket == nil {
+				return nil
+			}
+			after := []byte{}
+			if lastEventID != "" {
+				if key := bucket.Get([]byte(idKeyPrefix + lastEventID)); key != nil {
+					after = key
+				}
+			}
+			c := bucket.Cursor()
+			idPrefix := []byte(idKeyPrefix)
+			for k, v := c.First(); k != nil; k, v = c.Next() {
+				if bytes.HasPrefix(k, idPrefix) || bytes.Compare(k, after) <= 0 {
+					continue
+				}
+				var stored boltEvent
+				if err := gob.NewDecoder(bytes.NewReader(v)).Decode(&stored); err != nil {
+					log.Println("eventsource: bolt transport decode failed:", err)
+					continue
+				}
+				out <- stored
+			}
+			return nil
+		})
+		if err != nil {
+			log.Println("eventsource: bolt transport history failed:", err)
+		}
+	}()
+	return out
+}
+
+func (t *boltTransport) Close() {
+	t.memoryTransport.Close()
+	if err := t.db.Close(); err != nil {
+		log.Println("eventsource: bolt transport close failed:", err)
+	}
+}
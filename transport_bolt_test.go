@@ -0,0 +1,37 @@
+package eventsource
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestBoltTransportHistorySkipsIdIndexKeys(t *testing.T) {
+	f, err := ioutil.TempFile("", "eventsource-bolt-*.db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := f.Name()
+	f.Close()
+	defer os.Remove(path)
+
+	transport, err := NewBoltTransport(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer transport.Close()
+
+	bt := transport.(*boltTransport)
+	// "10000" is 5 bytes, so its "id:"+ID secondary-index key is exactly 8
+	// bytes - the same length as a sequence key - which used to be
+	// misidentified as an event record by History.
+	bt.Dispatch([]string{"c"}, testEvent("10000"))
+
+	var got []Event
+	for ev := range bt.History("c", "") {
+		got = append(got, ev)
+	}
+	if len(got) != 1 || got[0].Id() != "10000" {
+		t.Fatalf("History() = %v, want exactly the one published event", got)
+	}
+}
@@ -0,0 +1,170 @@
+package eventsource
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type testEvent string
+
+func (e testEvent) Id() string    { return string(e) }
+func (e testEvent) Event() string { return "msg" }
+func (e testEvent) Data() string  { return string(e) }
+
+func newTestSub(policy SlowClientPolicy, bufSize int) *subscription {
+	return &subscription{
+		channel:    "c",
+		topics:     []string{"c"},
+		out:        make(chan Event, bufSize),
+		cout:       make(chan string, bufSize),
+		queueTimes: make(chan time.Time, bufSize),
+		policy:     policy,
+	}
+}
+
+func TestDispatchDropOldest(t *testing.T) {
+	sub := newTestSub(DropOldest, 2)
+	transport := newMemoryTransport()
+	transport.AddSubscriber(sub)
+
+	for _, id := range []string{"1", "2", "3"} {
+		delivered, dropped, disconnected := transport.Dispatch([]string{"c"}, testEvent(id))
+		if delivered != 1 || dropped != 0 || disconnected != 0 {
+			t.Fatalf("Dispatch(%s) = (%d,%d,%d), want (1,0,0)", id, delivered, dropped, disconnected)
+		}
+	}
+	if sub.dropped != 0 {
+		t.Fatalf("sub.dropped = %d, want 0", sub.dropped)
+	}
+	got := []string{(<-sub.out).Id(), (<-sub.out).Id()}
+	if got[0] != "2" || got[1] != "3" {
+		t.Fatalf("buffered events = %v, want [2 3] (oldest should have been evicted)", got)
+	}
+}
+
+func TestDispatchDropNewest(t *testing.T) {
+	sub := newTestSub(DropNewest, 2)
+	transport := newMemoryTransport()
+	transport.AddSubscriber(sub)
+
+	delivered, dropped, _ := transport.Dispatch([]string{"c"}, testEvent("1"))
+	if delivered != 1 || dropped != 0 {
+		t.Fatalf("Dispatch(1) = (%d,%d), want (1,0)", delivered, dropped)
+	}
+	delivered, dropped, _ = transport.Dispatch([]string{"c"}, testEvent("2"))
+	if delivered != 1 || dropped != 0 {
+		t.Fatalf("Dispatch(2) = (%d,%d), want (1,0)", delivered, dropped)
+	}
+	delivered, dropped, _ = transport.Dispatch([]string{"c"}, testEvent("3"))
+	if delivered != 0 || dropped != 1 {
+		t.Fatalf("Dispatch(3) = (%d,%d), want (0,1)", delivered, dropped)
+	}
+	if sub.dropped != 1 {
+		t.Fatalf("sub.dropped = %d, want 1", sub.dropped)
+	}
+	got := []string{(<-sub.out).Id(), (<-sub.out).Id()}
+	if got[0] != "1" || got[1] != "2" {
+		t.Fatalf("buffered events = %v, want [1 2] (newest should have been discarded)", got)
+	}
+}
+
+func TestDispatchBlock(t *testing.T) {
+	sub := newTestSub(Block, 1)
+	transport := newMemoryTransport()
+	transport.AddSubscriber(sub)
+
+	done := make(chan struct{})
+	go func() {
+		transport.Dispatch([]string{"c"}, testEvent("1"))
+		transport.Dispatch([]string{"c"}, testEvent("2")) // blocks until "1" is drained
+		close(done)
+	}()
+
+	select {
+	case ev := <-sub.out:
+		if ev.Id() != "1" {
+			t.Fatalf("got id %q, want %q", ev.Id(), "1")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for first event")
+	}
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Dispatch with Block policy did not unblock once the buffer was drained")
+	}
+	if ev := <-sub.out; ev.Id() != "2" {
+		t.Fatalf("got id %q, want %q", ev.Id(), "2")
+	}
+}
+
+func TestDispatchDisconnect(t *testing.T) {
+	sub := newTestSub(Disconnect, 1)
+	transport := newMemoryTransport()
+	transport.AddSubscriber(sub)
+
+	delivered, _, disconnected := transport.Dispatch([]string{"c"}, testEvent("1"))
+	if delivered != 1 || disconnected != 0 {
+		t.Fatalf("Dispatch(1) = (%d,_,%d), want (1,0)", delivered, disconnected)
+	}
+	_, _, disconnected = transport.Dispatch([]string{"c"}, testEvent("2"))
+	if disconnected != 1 {
+		t.Fatalf("Dispatch(2) disconnected = %d, want 1", disconnected)
+	}
+	if !sub.removed {
+		t.Fatal("expected subscriber to be marked removed once its buffer overflowed")
+	}
+	if ev, ok := <-sub.out; !ok || ev.Id() != "1" {
+		t.Fatalf("expected the buffered event to still be readable, got %v, ok=%v", ev, ok)
+	}
+	if _, ok := <-sub.out; ok {
+		t.Fatal("expected sub.out to be closed once the subscriber was disconnected")
+	}
+}
+
+func TestDropOldestQueueTime(t *testing.T) {
+	sub := newTestSub(DropOldest, 1)
+	transport := newMemoryTransport()
+	transport.AddSubscriber(sub)
+
+	transport.Dispatch([]string{"c"}, testEvent("1"))
+	transport.Dispatch([]string{"c"}, testEvent("2")) // evicts "1" and its queue-time sample
+
+	<-sub.out
+	sub.recordDequeue()
+
+	if averageQueueTime(sub) <= 0 {
+		t.Fatal("expected a non-zero average queue time for the delivered event")
+	}
+	if samples := atomic.LoadUint64(&sub.queueSamples); samples != 1 {
+		t.Fatalf("queueSamples = %d, want 1 (the evicted sample must not be double-counted)", samples)
+	}
+}
+
+func TestPublishAndWaitAckCounts(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+	srv.BufferSize = 1
+
+	sub := &subscription{
+		channel:    "c",
+		topics:     []string{"c"},
+		out:        make(chan Event, srv.BufferSize),
+		cout:       make(chan string, srv.BufferSize),
+		queueTimes: make(chan time.Time, srv.BufferSize),
+		policy:     Disconnect,
+	}
+	srv.subs <- sub
+
+	delivered, disconnected := srv.PublishAndWait([]string{"c"}, testEvent("1"), time.Second)
+	if delivered != 1 || disconnected != 0 {
+		t.Fatalf("first PublishAndWait = (%d,%d), want (1,0)", delivered, disconnected)
+	}
+
+	delivered, disconnected = srv.PublishAndWait([]string{"c"}, testEvent("2"), time.Second)
+	if delivered != 0 || disconnected != 1 {
+		t.Fatalf("second PublishAndWait = (%d,%d), want (0,1)", delivered, disconnected)
+	}
+}